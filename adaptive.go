@@ -5,7 +5,6 @@ import (
 	"errors"
 	"math"
 	"math/rand"
-	"sync"
 	"time"
 
 	"github.com/deixis/faults"
@@ -29,10 +28,31 @@ type AdaptiveThrottle struct {
 	minPerWindow float64
 	// isErrorAccepted returns true when the error should not be counted towards the throttling.
 	isErrorAccepted func(error) bool
+	// now and random are injected so tests can drive the throttle deterministically, mirroring
+	// the gRPC RLS adaptive throttler's timeNowFunc/randFunc.
+	now    func() time.Time
+	random func() float64
+	// priorityConfigs, when non-nil, overrides k and minPerWindow on a per-priority basis. See
+	// WithAdaptiveThrottlePriorityConfig.
+	priorityConfigs []PriorityConfig
 
-	m        sync.Mutex
-	requests []windowedCounter
-	accepts  []windowedCounter
+	counters *shardedCounters
+}
+
+// PriorityConfig overrides the AdaptiveThrottle's behaviour for a single priority tier, in place
+// of the throttle-wide K and MinRate. See WithAdaptiveThrottlePriorityConfig.
+type PriorityConfig struct {
+	// K is this priority's ratio of measured success rate to admitted rate. See
+	// WithAdaptiveThrottleRatio.
+	K float64
+	// MinRate is this priority's minimum requests per second. See WithAdaptiveThrottleMinimumRate.
+	MinRate float64
+	// ReservedFraction is the fraction of this priority's own request volume that is reserved as
+	// protected headroom for this tier against every lower priority (i.e. every priority with a
+	// larger index): it is
+	// added to the numerator of those priorities' rejection probability, so a flood of low-priority
+	// traffic cannot grow to consume the capacity this tier needs.
+	ReservedFraction float64
 }
 
 // Additional options for the AdaptiveThrottle type. These options do not frequently need to be
@@ -46,6 +66,9 @@ type adaptiveThrottleOptions struct {
 	minRate         float64
 	d               time.Duration
 	isErrorAccepted func(err error) bool
+	now             func() time.Time
+	random          func() float64
+	priorityConfigs []PriorityConfig
 }
 
 // WithAdaptiveThrottleRatio sets the ratio of the measured success rate and the rate that the throttle
@@ -87,6 +110,37 @@ func WithAcceptedErrors(fn func(err error) bool) AdaptiveThrottleOption {
 	}}
 }
 
+// WithClock overrides the function used to read the current time. This is intended for tests that
+// need to drive the throttle's time windows deterministically; production callers should not need
+// this option.
+func WithClock(fn func() time.Time) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.now = fn
+	}}
+}
+
+// WithRand overrides the function used to draw the random number compared against the rejection
+// probability. This is intended for tests that need deterministic accept/reject decisions;
+// production callers should not need this option.
+func WithRand(fn func() float64) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.random = fn
+	}}
+}
+
+// WithAdaptiveThrottlePriorityConfig sets a PriorityConfig per priority, overriding K and MinRate
+// throttle-wide and reserving capacity for higher priorities against lower ones. configs must have
+// exactly as many entries as the throttle has priorities, in priority order (index 0 is the
+// highest priority). This lets operators run cheap, cache-like priorities (K close to 1) alongside
+// expensive-write priorities (K=3) in the same throttle instance, and guarantees that a flood of
+// low-priority requests cannot fully starve higher-priority admission the way a single global K
+// and MinRate would allow.
+func WithAdaptiveThrottlePriorityConfig(configs []PriorityConfig) AdaptiveThrottleOption {
+	return AdaptiveThrottleOption{func(opts *adaptiveThrottleOptions) {
+		opts.priorityConfigs = configs
+	}}
+}
+
 // NewAdaptiveThrottle returns an AdaptiveThrottle.
 //
 // priorities is the number of priorities that the throttle will accept. Giving a priority outside
@@ -97,26 +151,65 @@ func NewAdaptiveThrottle(priorities int, options ...AdaptiveThrottleOption) *Ada
 		k:               2,
 		minRate:         1,
 		isErrorAccepted: DefaultAcceptedErrors,
+		now:             time.Now,
+		random:          rand.Float64,
 	}
 	for _, option := range options {
 		option.f(&opts)
 	}
+	if opts.priorityConfigs != nil && len(opts.priorityConfigs) != priorities {
+		panic("bulwark: priority configs must have exactly as many entries as priorities")
+	}
 
-	now := time.Now()
-	requests := make([]windowedCounter, priorities)
-	accepts := make([]windowedCounter, priorities)
-	for i := range requests {
-		requests[i] = newWindowedCounter(now, opts.d/10, 10)
-		accepts[i] = newWindowedCounter(now, opts.d/10, 10)
+	// priorityConfigs is stored with MinRate already converted from requests/sec, the unit
+	// PriorityConfig documents, to a per-window count, matching minPerWindow below. Converting once
+	// here keeps rejectionProbability from having to carry the window length around separately.
+	var priorityConfigs []PriorityConfig
+	if opts.priorityConfigs != nil {
+		priorityConfigs = make([]PriorityConfig, len(opts.priorityConfigs))
+		for i, c := range opts.priorityConfigs {
+			c.MinRate *= opts.d.Seconds()
+			priorityConfigs[i] = c
+		}
 	}
 
 	return &AdaptiveThrottle{
 		k:               opts.k,
-		requests:        requests,
-		accepts:         accepts,
+		counters:        newShardedCounters(priorities, opts.d/10, 10),
 		minPerWindow:    opts.minRate * opts.d.Seconds(),
 		isErrorAccepted: opts.isErrorAccepted,
+		now:             opts.now,
+		random:          opts.random,
+		priorityConfigs: priorityConfigs,
+	}
+}
+
+// Acquire implements Throttler. ctx is not used by AdaptiveThrottle, which never blocks: it either
+// admits the request immediately or rejects it with DefaultClientSideRejectionError.
+func (at *AdaptiveThrottle) Acquire(ctx context.Context, p Priority) (Ticket, error) {
+	now := at.now()
+
+	_, _, rejectionProbability := at.rejectionProbability(now, p)
+
+	if at.random() < rejectionProbability {
+		at.counters.addRequest(now, p)
+		return nil, DefaultClientSideRejectionError
 	}
+
+	return &adaptiveThrottleTicket{at: at, p: p}, nil
+}
+
+type adaptiveThrottleTicket struct {
+	at *AdaptiveThrottle
+	p  Priority
+}
+
+// Release implements Ticket. err should be the outcome of whatever request Acquire admitted; it is
+// counted as a rejection towards at's success rate unless it is nil or accepted by
+// at.isErrorAccepted.
+func (t *adaptiveThrottleTicket) Release(err error) {
+	accepted := err == nil || t.at.isErrorAccepted(err)
+	t.at.counters.addOutcome(t.at.now(), t.p, accepted)
 }
 
 // WithAdaptiveThrottle is used to send a request to a backend using the given AdaptiveThrottle for
@@ -128,52 +221,69 @@ func NewAdaptiveThrottle(priorities int, options ...AdaptiveThrottleOption) *Ada
 // rate at which this happens depends on the error rate of f.
 //
 // WithAdaptiveThrottle will prefer to reject lower-priority requests if it can.
+//
+// WithAdaptiveThrottle is a thin convenience wrapper over at.Acquire/Ticket.Release; prefer the
+// Throttler interface directly when composing at with other throttlers via Chain.
 func WithAdaptiveThrottle[T any](
 	at *AdaptiveThrottle,
 	p Priority,
 	f func() (T, error),
 ) (T, error) {
-	now := time.Now()
-
-	// Lifted rather directly from https://sre.google/sre-book/handling-overload/, with two
-	// extensions:
-	// - We count higher priorities' non-accepts as non-accepts, since we're trying to estimate
-	//   roughly how many requests we can send through without causing rejections for higher
-	//   priorities.
-	// - minPerWindow is configurable, in the book it's always 1 meaning ~1 QPS is the minimum
-	//   allowed.
-	at.m.Lock()
-	requests := float64(at.requests[int(p)].get(now))
-	accepts := float64(at.accepts[int(p)].get(now))
-	for i := 0; i < int(p); i++ {
-		// Also count non-accepted requests for every higher priority as non-accepted for this
-		// priority.
-		requests += float64(at.requests[i].get(now) - at.accepts[i].get(now))
+	ticket, err := at.Acquire(context.Background(), p)
+	if err != nil {
+		var zero T
+		return zero, err
 	}
-	at.m.Unlock()
 
-	rejectionProbability := math.Max(0, (requests-at.k*accepts)/(requests+at.minPerWindow))
+	t, err := f()
+	ticket.Release(err)
+	return t, err
+}
 
-	if rand.Float64() < rejectionProbability {
-		var zero T
-		at.m.Lock()
-		at.requests[int(p)].add(now, 1)
-		at.m.Unlock()
+// Stats returns the current request and accept counts for priority p over the throttle's window,
+// along with the rejection probability they produce, without mutating any state. It is intended
+// for tests and metrics exporters (e.g. Prometheus/OpenTelemetry) that need to observe the
+// throttle's decision curve.
+func (at *AdaptiveThrottle) Stats(p Priority) (requests, accepts int64, rejectionProbability float64) {
+	return at.rejectionProbability(at.now(), p)
+}
 
-		return zero, DefaultClientSideRejectionError
+// rejectionProbability computes priority p's current rejection probability, along with its raw
+// request and accept counts over the window, as of now. Lifted rather directly from
+// https://sre.google/sre-book/handling-overload/, with three extensions:
+//   - We count higher priorities' non-accepts as non-accepts, since we're trying to estimate
+//     roughly how many requests we can send through without causing rejections for higher
+//     priorities.
+//   - minPerWindow is configurable, in the book it's always 1 meaning ~1 QPS is the minimum
+//     allowed.
+//   - When priorityConfigs is set, K and minPerWindow are taken per-priority, and a share of each
+//     higher priority's own traffic (its ReservedFraction) is reserved against p's budget, so that
+//     a flood of low-priority traffic cannot starve higher priorities the way a single global K and
+//     MinRate would allow.
+func (at *AdaptiveThrottle) rejectionProbability(now time.Time, p Priority) (requests, accepts int64, rejectionProbability float64) {
+	k := at.k
+	minPerWindow := at.minPerWindow
+	if at.priorityConfigs != nil {
+		k = at.priorityConfigs[int(p)].K
+		minPerWindow = at.priorityConfigs[int(p)].MinRate
 	}
 
-	t, err := f()
-
-	now = time.Now()
-	at.m.Lock()
-	at.requests[int(p)].add(now, 1)
-	if err == nil || at.isErrorAccepted(err) {
-		at.accepts[int(p)].add(now, 1)
+	allRequests, allAccepts := at.counters.getUpTo(now, int(p)+1)
+	reqs, acc := allRequests[int(p)], allAccepts[int(p)]
+	reqsF := float64(reqs)
+	var reservedForHigher float64
+	for i := 0; i < int(p); i++ {
+		// Also count non-accepted requests for every higher priority as non-accepted for this
+		// priority.
+		reqsF += float64(allRequests[i] - allAccepts[i])
+		if at.priorityConfigs != nil {
+			reservedForHigher += at.priorityConfigs[i].ReservedFraction * float64(allRequests[i])
+		}
 	}
-	at.m.Unlock()
 
-	return t, err
+	// reservedForHigher can push the ratio above 1 (or to +Inf with an empty window and no
+	// minimum), so clamp it to a probability.
+	return reqs, acc, math.Min(1, math.Max(0, (reqsF-k*float64(acc)+reservedForHigher)/(reqsF+minPerWindow)))
 }
 
 var (
@@ -0,0 +1,146 @@
+package bulwark
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveThrottle_DeterministicCurve drives the throttle with an injected clock and random
+// source, the scenario WithClock/WithRand exist for, and checks that Stats reports exactly the
+// accept/reject curve the formula predicts.
+func TestAdaptiveThrottle_DeterministicCurve(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	var randVal float64
+	rnd := func() float64 { return randVal }
+
+	at := NewAdaptiveThrottle(1,
+		WithClock(clock),
+		WithRand(rnd),
+		WithAdaptiveThrottleRatio(2),
+		WithAdaptiveThrottleMinimumRate(0),
+	)
+
+	// rnd returns 0 throughout, and 0 < rejectionProbability is false until the probability is
+	// strictly positive, so only the first request reaches f and is recorded as a failure. That
+	// failure lifts the probability to 1, so the other nine are rejected without calling f; each
+	// rejection is still counted as a request with no accept.
+	boom := errors.New("boom")
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		_, _ = WithAdaptiveThrottle(at, Priority(0), func() (struct{}, error) {
+			return struct{}{}, boom
+		})
+	}
+
+	reqs, accepts, prob := at.Stats(Priority(0))
+	if reqs != 10 || accepts != 0 {
+		t.Fatalf("requests=%d accepts=%d, want 10/0", reqs, accepts)
+	}
+	// max(0, (10 - 2*0)/(10+0)) = 1
+	if prob != 1 {
+		t.Fatalf("rejectionProbability = %v, want 1", prob)
+	}
+
+	// rnd() < rejectionProbability (0.999 < 1) rejects without calling f.
+	randVal = 0.999
+	called := false
+	_, err := WithAdaptiveThrottle(at, Priority(0), func() (struct{}, error) {
+		called = true
+		return struct{}{}, nil
+	})
+	if called {
+		t.Fatal("f was called despite rand() < rejectionProbability")
+	}
+	if err != DefaultClientSideRejectionError {
+		t.Fatalf("err = %v, want DefaultClientSideRejectionError", err)
+	}
+
+	// rnd() < rejectionProbability (1 < 1) is false, so this one goes through.
+	randVal = 1
+	called = false
+	_, err = WithAdaptiveThrottle(at, Priority(0), func() (struct{}, error) {
+		called = true
+		return struct{}{}, nil
+	})
+	if !called {
+		t.Fatal("f was not called despite rand() == rejectionProbability")
+	}
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+}
+
+// TestAdaptiveThrottle_PriorityConfigMinRateUnits pins the regression where
+// WithAdaptiveThrottlePriorityConfig's MinRate, documented in requests/sec like
+// WithAdaptiveThrottleMinimumRate, was used directly as a per-window count: an equivalent global
+// and per-priority config must produce the same rejection probability for the same traffic.
+func TestAdaptiveThrottle_PriorityConfigMinRateUnits(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	rnd := func() float64 { return 1 } // never reject, so every attempt reaches f and is counted
+
+	global := NewAdaptiveThrottle(1,
+		WithClock(clock), WithRand(rnd),
+		WithAdaptiveThrottleRatio(2), WithAdaptiveThrottleMinimumRate(1))
+	perPriority := NewAdaptiveThrottle(1,
+		WithClock(clock), WithRand(rnd),
+		WithAdaptiveThrottlePriorityConfig([]PriorityConfig{{K: 2, MinRate: 1}}))
+
+	boom := errors.New("boom")
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Millisecond)
+		_, _ = WithAdaptiveThrottle(global, Priority(0), func() (struct{}, error) { return struct{}{}, boom })
+		_, _ = WithAdaptiveThrottle(perPriority, Priority(0), func() (struct{}, error) { return struct{}{}, boom })
+	}
+
+	_, _, globalProb := global.Stats(Priority(0))
+	_, _, perPriorityProb := perPriority.Stats(Priority(0))
+
+	if math.Abs(globalProb-perPriorityProb) > 1e-9 {
+		t.Fatalf("global config gave %v, equivalent per-priority config gave %v", globalProb, perPriorityProb)
+	}
+	// 100 requests, 0 accepts, K=2, MinRate=1/sec over the default 1-minute window -> minPerWindow
+	// = 60: max(0, (100 - 2*0)/(100+60)) = 0.625.
+	if math.Abs(globalProb-0.625) > 1e-9 {
+		t.Fatalf("rejectionProbability = %v, want 0.625", globalProb)
+	}
+}
+
+// TestAdaptiveThrottle_ReservedFraction checks that a higher priority's ReservedFraction is added
+// to a lower priority's rejection-probability numerator, reserving headroom for the higher
+// priority: a lower priority whose own requests all succeed, and so would see a rejection
+// probability of zero on its own, must see a non-zero (and in this case, maximal) one once the
+// higher priority reserves against it.
+func TestAdaptiveThrottle_ReservedFraction(t *testing.T) {
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+	rnd := func() float64 { return 1 } // never reject, so every attempt is counted
+
+	at := NewAdaptiveThrottle(2,
+		WithClock(clock), WithRand(rnd),
+		WithAdaptiveThrottlePriorityConfig([]PriorityConfig{
+			{K: 2, ReservedFraction: 0.9},
+			{K: 2},
+		}))
+
+	// Priority 0 (high) sends 1000 healthy requests.
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Millisecond)
+		_, _ = WithAdaptiveThrottle(at, Priority(0), func() (struct{}, error) { return struct{}{}, nil })
+	}
+	// Priority 1 (low) sends 100 requests that all succeed. Without a reservation its probability
+	// would be max(0, (100 - 2*100)/(100+0)) = 0; with it, (100 - 200 + 0.9*1000)/100 = 8, capped
+	// at 1.
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Millisecond)
+		_, _ = WithAdaptiveThrottle(at, Priority(1), func() (struct{}, error) { return struct{}{}, nil })
+	}
+
+	_, _, prob := at.Stats(Priority(1))
+	if prob <= 0 {
+		t.Fatalf("rejectionProbability for the healthy low priority = %v, want > 0: priority 0's ReservedFraction must reserve headroom against it", prob)
+	}
+}
@@ -0,0 +1,170 @@
+package bulwark
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/deixis/faults"
+)
+
+// ConcurrencyLimiter is used on a server to bound the number of requests being handled at once,
+// modeled after chi's ThrottleBacklog middleware. Requests beyond Limit are queued up to
+// BacklogLimit deep, and a queued request that waits longer than BacklogTimeout is rejected rather
+// than admitted. This gives a service a shipped way to enforce its own capacity ceiling, which
+// complements the client-side backpressure signal that AdaptiveThrottle reacts to.
+type ConcurrencyLimiter struct {
+	limit          int
+	backlogLimit   int
+	backlogTimeout time.Duration
+
+	m        sync.Mutex
+	inFlight int
+	// waitersByPriority holds, per priority, the FIFO queue of channels waiting for a slot. Index 0
+	// is the highest priority, matching AdaptiveThrottle's convention. A freed slot is handed to the
+	// front of the highest-priority non-empty queue, so higher-priority waiters are always served
+	// ahead of lower-priority ones, however long the lower-priority ones have been queued.
+	waitersByPriority [][]chan struct{}
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter.
+//
+// priorities is the number of priorities that the limiter will accept. Giving a priority outside
+// of `[0, priorities)` to WithConcurrencyLimiter will panic.
+func NewConcurrencyLimiter(priorities, limit, backlogLimit int, backlogTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		limit:             limit,
+		backlogLimit:      backlogLimit,
+		backlogTimeout:    backlogTimeout,
+		waitersByPriority: make([][]chan struct{}, priorities),
+	}
+}
+
+// Acquire implements Throttler. It blocks in the backlog until a slot is free or ctx/BacklogTimeout
+// expires, whichever comes first.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, p Priority) (Ticket, error) {
+	if err := cl.acquire(ctx, p); err != nil {
+		return nil, err
+	}
+	return &concurrencyLimiterTicket{cl: cl}, nil
+}
+
+type concurrencyLimiterTicket struct {
+	cl *ConcurrencyLimiter
+}
+
+// Release implements Ticket. err is unused: ConcurrencyLimiter only cares about concurrency, not
+// outcome.
+func (t *concurrencyLimiterTicket) Release(err error) {
+	t.cl.releaseSlot()
+}
+
+// WithConcurrencyLimiter runs f, gated by cl. Higher priority requests are admitted ahead of
+// lower priority ones: when the limiter is at capacity, a freed slot always goes to the
+// longest-waiting request at the highest priority with anyone queued, and a request is turned away
+// immediately, without ever entering the backlog, if doing so would put it behind BacklogLimit or
+// more requests at its own priority or above. A request that spends longer than
+// BacklogTimeout in the backlog is also turned away.
+//
+// Rejections are returned as faults.Unavailable(d), where d is a hint for how long the caller
+// should wait before retrying.
+//
+// WithConcurrencyLimiter is a thin convenience wrapper over cl.Acquire/Ticket.Release; prefer the
+// Throttler interface directly when composing cl with other throttlers via Chain.
+func WithConcurrencyLimiter[T any](
+	cl *ConcurrencyLimiter,
+	p Priority,
+	ctx context.Context,
+	f func() (T, error),
+) (T, error) {
+	ticket, err := cl.Acquire(ctx, p)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer ticket.Release(nil)
+
+	return f()
+}
+
+func (cl *ConcurrencyLimiter) acquire(ctx context.Context, p Priority) error {
+	cl.m.Lock()
+	if cl.inFlight < cl.limit {
+		cl.inFlight++
+		cl.m.Unlock()
+		return nil
+	}
+	if cl.backlogAtOrAboveLocked(p) >= cl.backlogLimit {
+		cl.m.Unlock()
+		return faults.Unavailable(cl.backlogTimeout)
+	}
+	ch := make(chan struct{}, 1)
+	cl.waitersByPriority[int(p)] = append(cl.waitersByPriority[int(p)], ch)
+	cl.m.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, cl.backlogTimeout)
+	defer cancel()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	cl.m.Lock()
+	removed := cl.removeWaiterLocked(p, ch)
+	cl.m.Unlock()
+	if removed {
+		return faults.Unavailable(cl.backlogTimeout)
+	}
+
+	// We lost the race: releaseSlot already popped us off the queue and is about to (or just did)
+	// hand us the slot on ch. Take it so the handoff doesn't block forever, then give it straight
+	// back so the next waiter, if any, gets it instead of it leaking as permanently in-flight.
+	<-ch
+	cl.releaseSlot()
+	return faults.Unavailable(cl.backlogTimeout)
+}
+
+// releaseSlot frees one in-flight slot. If any priority has a queued waiter, the slot is handed
+// directly to the front of the highest-priority non-empty queue instead of being freed, so
+// in-flight count is unaffected; otherwise inFlight is decremented.
+func (cl *ConcurrencyLimiter) releaseSlot() {
+	cl.m.Lock()
+	for i := range cl.waitersByPriority {
+		if len(cl.waitersByPriority[i]) == 0 {
+			continue
+		}
+		ch := cl.waitersByPriority[i][0]
+		cl.waitersByPriority[i] = cl.waitersByPriority[i][1:]
+		cl.m.Unlock()
+		ch <- struct{}{}
+		return
+	}
+	cl.inFlight--
+	cl.m.Unlock()
+}
+
+// removeWaiterLocked removes ch from priority p's waiter queue, if it is still there. It returns
+// false when ch has already been popped by releaseSlot, meaning a slot was (or is about to be)
+// handed to it.
+func (cl *ConcurrencyLimiter) removeWaiterLocked(p Priority, ch chan struct{}) bool {
+	q := cl.waitersByPriority[int(p)]
+	for i, c := range q {
+		if c == ch {
+			cl.waitersByPriority[int(p)] = append(q[:i], q[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// backlogAtOrAboveLocked returns the number of requests currently queued at priority p or higher,
+// which is what a newly arriving request at p would have to wait behind.
+func (cl *ConcurrencyLimiter) backlogAtOrAboveLocked(p Priority) int {
+	total := 0
+	for i := 0; i <= int(p); i++ {
+		total += len(cl.waitersByPriority[i])
+	}
+	return total
+}
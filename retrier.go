@@ -0,0 +1,305 @@
+package bulwark
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/deixis/faults"
+)
+
+// AdaptiveRetrier bounds the rate at which a client issues requests to a backend that is
+// explicitly signalling backpressure (e.g. throttling errors), as opposed to AdaptiveThrottle
+// which infers backend health from the observed success rate. It works as a token bucket whose
+// refill rate is adjusted using the same CUBIC-style rate discovery as the AWS SDK's adaptive
+// retry mode: every throttling error halves the measured send rate and starts a new growth curve,
+// while a run of successes lets the rate climb back up along that curve.
+//
+// Each Priority gets its own bucket, sized as an equal share of maxTokens, so a flood of
+// low-priority traffic that trips the backend's throttling cannot back off the rate available to
+// higher-priority traffic -- the same reason AdaptiveThrottle and ConcurrencyLimiter keep separate
+// state per priority instead of one pool shared by every caller.
+//
+// This is meant to be used alongside, not instead of, AdaptiveThrottle: the throttle reacts to
+// client-observed error rates, while the retrier reacts to a backend that is telling callers
+// outright that they are sending too fast.
+type AdaptiveRetrier struct {
+	requestCost     float64
+	failOnNoTokens  bool
+	isThrottleError func(error) bool
+
+	buckets []retrierBucket
+}
+
+// retrierBucket is one priority's independent token bucket and CUBIC recovery state.
+type retrierBucket struct {
+	m               sync.Mutex
+	tokens          float64
+	maxTokens       float64
+	fillRate        float64
+	lastTokenUpdate time.Time
+
+	measuredTxRate   float64
+	lastTxRateBucket float64
+	lastTxRateUpdate time.Time
+
+	lastThrottleTime time.Time
+	lastMaxRate      float64
+}
+
+// AdaptiveRetrierOption configures an AdaptiveRetrier. These options do not frequently need to be
+// tuned as the defaults work in a majority of cases.
+type AdaptiveRetrierOption struct {
+	f func(*adaptiveRetrierOptions)
+}
+
+type adaptiveRetrierOptions struct {
+	requestCost     float64
+	maxTokens       float64
+	failOnNoTokens  bool
+	isThrottleError func(error) bool
+}
+
+// WithRequestCost sets the number of tokens a single request acquires from the bucket before
+// being let through. Requests that are known to be more expensive for the backend to process can
+// be given a higher cost so that they consume a larger share of the allowed send rate.
+func WithRequestCost(cost float64) AdaptiveRetrierOption {
+	return AdaptiveRetrierOption{func(opts *adaptiveRetrierOptions) {
+		opts.requestCost = cost
+	}}
+}
+
+// WithFailOnNoTokens sets whether WithAdaptiveRetry returns ErrClientRejection immediately when
+// the bucket has no tokens available, rather than blocking until one is. This is false by default,
+// meaning callers sleep until a token frees up.
+func WithFailOnNoTokens(fail bool) AdaptiveRetrierOption {
+	return AdaptiveRetrierOption{func(opts *adaptiveRetrierOptions) {
+		opts.failOnNoTokens = fail
+	}}
+}
+
+// WithThrottleClassifier sets the function used to decide whether an error returned by f should be
+// treated as an explicit backend throttling signal. The default recognises
+// faults.IsResourceExhausted and faults.IsUnavailable.
+func WithThrottleClassifier(fn func(error) bool) AdaptiveRetrierOption {
+	return AdaptiveRetrierOption{func(opts *adaptiveRetrierOptions) {
+		opts.isThrottleError = fn
+	}}
+}
+
+// NewAdaptiveRetrier returns an AdaptiveRetrier whose maxTokens bucket is split into equal shares
+// across priorities.
+//
+// priorities is the number of priorities that the retrier will accept. Giving a priority outside
+// of `[0, priorities)` will panic. NewAdaptiveRetrier itself panics if WithRequestCost is set
+// higher than a single priority's share of maxTokens, since such a bucket could never refill
+// enough to admit a request.
+func NewAdaptiveRetrier(priorities int, maxTokens float64, options ...AdaptiveRetrierOption) *AdaptiveRetrier {
+	opts := adaptiveRetrierOptions{
+		requestCost:     1,
+		maxTokens:       maxTokens,
+		failOnNoTokens:  false,
+		isThrottleError: DefaultThrottleClassifier,
+	}
+	for _, option := range options {
+		option.f(&opts)
+	}
+
+	perPriority := opts.maxTokens / float64(priorities)
+	if opts.requestCost > perPriority {
+		panic(fmt.Sprintf(
+			"bulwark: request cost %v exceeds per-priority bucket size %v (maxTokens %v / %d priorities); tokens could never refill enough to admit a request",
+			opts.requestCost, perPriority, opts.maxTokens, priorities,
+		))
+	}
+
+	now := time.Now()
+	buckets := make([]retrierBucket, priorities)
+	for i := range buckets {
+		buckets[i] = retrierBucket{
+			tokens:           perPriority,
+			maxTokens:        perPriority,
+			fillRate:         perPriority,
+			lastTokenUpdate:  now,
+			lastTxRateUpdate: now,
+			lastThrottleTime: now,
+		}
+	}
+
+	return &AdaptiveRetrier{
+		requestCost:     opts.requestCost,
+		failOnNoTokens:  opts.failOnNoTokens,
+		isThrottleError: opts.isThrottleError,
+		buckets:         buckets,
+	}
+}
+
+// Acquire implements Throttler. It blocks until a token is available, or returns ErrClientRejection
+// immediately when WithFailOnNoTokens is set, or returns ctx.Err() if ctx is done first.
+func (ar *AdaptiveRetrier) Acquire(ctx context.Context, p Priority) (Ticket, error) {
+	bucket := &ar.buckets[int(p)]
+	if err := ar.acquire(ctx, bucket); err != nil {
+		return nil, err
+	}
+	return &adaptiveRetrierTicket{ar: ar, bucket: bucket}, nil
+}
+
+type adaptiveRetrierTicket struct {
+	ar     *AdaptiveRetrier
+	bucket *retrierBucket
+}
+
+// Release implements Ticket. err should be the outcome of whatever request Acquire admitted; a
+// throttling error (per ar's WithThrottleClassifier) reduces the bucket's refill rate, while
+// anything else lets it grow back along the CUBIC recovery curve.
+func (t *adaptiveRetrierTicket) Release(err error) {
+	t.ar.update(t.bucket, err)
+}
+
+// WithAdaptiveRetry sends a request to a backend, gated by the given AdaptiveRetrier. It first
+// acquires RequestCost tokens from p's bucket, sleeping until they are available, or returning
+// ErrClientRejection immediately when WithFailOnNoTokens is set. Once acquired, it calls f and
+// uses the outcome to steer that bucket's refill rate: throttling errors (as classified by
+// WithThrottleClassifier) reduce the rate, while successes let it grow back.
+//
+// WithAdaptiveRetry is a thin convenience wrapper over ar.Acquire/Ticket.Release; prefer the
+// Throttler interface directly when composing ar with other throttlers via Chain.
+func WithAdaptiveRetry[T any](
+	ar *AdaptiveRetrier,
+	p Priority,
+	f func() (T, error),
+) (T, error) {
+	ticket, err := ar.Acquire(context.Background(), p)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	t, err := f()
+	ticket.Release(err)
+	return t, err
+}
+
+func (ar *AdaptiveRetrier) acquire(ctx context.Context, bucket *retrierBucket) error {
+	for {
+		bucket.m.Lock()
+		bucket.refillLocked(time.Now())
+
+		if bucket.tokens >= ar.requestCost {
+			bucket.tokens -= ar.requestCost
+			bucket.m.Unlock()
+			return nil
+		}
+
+		if ar.failOnNoTokens {
+			bucket.m.Unlock()
+			return ErrClientRejection
+		}
+
+		wait := time.Duration((ar.requestCost - bucket.tokens) / bucket.fillRate * float64(time.Second))
+		bucket.m.Unlock()
+
+		if wait <= 0 {
+			wait = time.Millisecond
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *retrierBucket) refillLocked(now time.Time) {
+	elapsed := now.Sub(b.lastTokenUpdate).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.maxTokens, b.tokens+elapsed*b.fillRate)
+	b.lastTokenUpdate = now
+}
+
+func (ar *AdaptiveRetrier) update(bucket *retrierBucket, err error) {
+	now := time.Now()
+
+	bucket.m.Lock()
+	defer bucket.m.Unlock()
+
+	bucket.refillLocked(now)
+	bucket.updateMeasuredTxRateLocked(now)
+
+	if ar.isThrottleError(err) {
+		bucket.lastMaxRate = bucket.measuredTxRate
+		bucket.lastThrottleTime = now
+		bucket.fillRate = math.Max(minFillRate, bucket.measuredTxRate*throttleBackoffRatio)
+		return
+	}
+
+	rate := math.Min(bucket.measuredTxRate, cubicRate(now.Sub(bucket.lastThrottleTime).Seconds(), bucket.lastMaxRate))
+	if rate > bucket.fillRate {
+		bucket.fillRate = rate
+	}
+}
+
+// updateMeasuredTxRateLocked keeps a smoothed estimate of the rate at which requests are actually
+// being sent through the bucket, in one-second buckets, so cubicRate has a ceiling to grow toward.
+func (b *retrierBucket) updateMeasuredTxRateLocked(now time.Time) {
+	const bucketWindow = time.Second
+	const smoothing = 0.5
+
+	b.lastTxRateBucket++
+	if now.Sub(b.lastTxRateUpdate) < bucketWindow {
+		return
+	}
+
+	buckets := now.Sub(b.lastTxRateUpdate).Seconds()
+	currentRate := b.lastTxRateBucket / buckets
+	b.measuredTxRate = smoothing*currentRate + (1-smoothing)*b.measuredTxRate
+	b.lastTxRateBucket = 0
+	b.lastTxRateUpdate = now
+}
+
+// cubicRate computes the CUBIC-style target rate t seconds after the last observed throttling
+// error, given wMax, the measured send rate at the time of that error. It starts at
+// cubicBeta*wMax, reaches wMax again after k = cbrt(wMax*(1-cubicBeta)/cubicScale) seconds and
+// keeps growing beyond it, mirroring the concave-then-convex recovery
+// curve used by the AWS SDK's adaptive retry mode and TCP CUBIC congestion control.
+func cubicRate(t, wMax float64) float64 {
+	if wMax <= 0 {
+		return minFillRate
+	}
+	k := math.Cbrt(wMax * (1 - cubicBeta) / cubicScale)
+	return cubicScale*math.Pow(t-k, 3) + wMax
+}
+
+const (
+	// cubicBeta sets where cubicRate's recovery curve starts: cubicRate(0, wMax) is
+	// cubicBeta*wMax. The fill rate set at the moment of a throttling error uses
+	// throttleBackoffRatio instead.
+	cubicBeta = 0.7
+	// cubicScale controls how aggressively cubicRate grows once past its inflection point.
+	cubicScale = 0.4
+	// throttleBackoffRatio is applied to the measured send rate to pick the new fill rate
+	// immediately after a throttling error, ahead of the slower cubic recovery.
+	throttleBackoffRatio = 0.5
+	// minFillRate is the lowest fill rate a bucket is ever set to, so it can still discover when
+	// the backend recovers.
+	minFillRate = 1
+)
+
+// DefaultThrottleClassifier is the default function used to determine whether an error should be
+// treated as an explicit backend throttling signal by AdaptiveRetrier.
+var DefaultThrottleClassifier = func(err error) bool {
+	return faults.IsResourceExhausted(err) || faults.IsUnavailable(err)
+}
+
+// ErrClientRejection is returned by WithAdaptiveRetry when WithFailOnNoTokens is set and no
+// token is immediately available. The one-second duration is a backoff hint for the caller, as in
+// DefaultClientSideRejectionError in adaptive.go, but the error is a faults.ResourceExhausted
+// rather than a faults.Unavailable.
+var ErrClientRejection = faults.ResourceExhausted(time.Second)
@@ -0,0 +1,86 @@
+package bulwark
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCubicRate checks the shape of the CUBIC recovery curve cubicRate implements: it dips below
+// wMax right after a throttling error, passes back through wMax exactly k seconds later, and grows
+// past it beyond that, mirroring the concave-then-convex curve the AWS SDK's adaptive retry mode
+// and TCP CUBIC both use.
+func TestCubicRate(t *testing.T) {
+	const wMax = 100.0
+	k := math.Cbrt(wMax * (1 - cubicBeta) / cubicScale)
+
+	if got := cubicRate(k, wMax); math.Abs(got-wMax) > 1e-9 {
+		t.Fatalf("cubicRate(k, wMax) = %v, want wMax (%v)", got, wMax)
+	}
+	if got := cubicRate(0, wMax); got >= wMax {
+		t.Fatalf("cubicRate(0, wMax) = %v, want less than wMax right after a throttling error", got)
+	}
+	if got := cubicRate(2*k, wMax); got <= wMax {
+		t.Fatalf("cubicRate(2*k, wMax) = %v, want greater than wMax past the inflection point", got)
+	}
+	if got := cubicRate(10, 0); got != minFillRate {
+		t.Fatalf("cubicRate with wMax <= 0 = %v, want minFillRate", got)
+	}
+}
+
+// TestAdaptiveRetrier_RequestCostExceedsBucketPanics pins the livelock guard: a request cost that a
+// priority's bucket could never refill up to must panic at construction rather than spin forever in
+// acquire().
+func TestAdaptiveRetrier_RequestCostExceedsBucketPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected NewAdaptiveRetrier to panic when request cost exceeds the per-priority bucket size")
+		}
+	}()
+	NewAdaptiveRetrier(2, 1, WithRequestCost(1)) // perPriority = 1/2 = 0.5, cost = 1
+}
+
+// TestAdaptiveRetrier_FailOnNoTokens checks that an exhausted bucket rejects immediately, without
+// calling f, when WithFailOnNoTokens is set.
+func TestAdaptiveRetrier_FailOnNoTokens(t *testing.T) {
+	ar := NewAdaptiveRetrier(1, 1, WithRequestCost(1), WithFailOnNoTokens(true))
+
+	if _, err := WithAdaptiveRetry(ar, Priority(0), func() (struct{}, error) {
+		return struct{}{}, nil
+	}); err != nil {
+		t.Fatalf("first request: unexpected error %v", err)
+	}
+
+	called := false
+	_, err := WithAdaptiveRetry(ar, Priority(0), func() (struct{}, error) {
+		called = true
+		return struct{}{}, nil
+	})
+	if called {
+		t.Fatal("f was called despite the bucket being empty")
+	}
+	if err != ErrClientRejection {
+		t.Fatalf("err = %v, want ErrClientRejection", err)
+	}
+}
+
+// TestAdaptiveRetrier_PrioritiesAreIndependent checks that each priority gets its own bucket:
+// exhausting one priority's tokens must not affect another's.
+func TestAdaptiveRetrier_PrioritiesAreIndependent(t *testing.T) {
+	ar := NewAdaptiveRetrier(2, 2, WithRequestCost(1), WithFailOnNoTokens(true)) // perPriority = 1 each
+
+	if _, err := WithAdaptiveRetry(ar, Priority(0), func() (struct{}, error) {
+		return struct{}{}, nil
+	}); err != nil {
+		t.Fatalf("priority 0 first request: unexpected error %v", err)
+	}
+	if _, err := WithAdaptiveRetry(ar, Priority(0), func() (struct{}, error) {
+		return struct{}{}, nil
+	}); err != ErrClientRejection {
+		t.Fatalf("priority 0 second request: err = %v, want ErrClientRejection", err)
+	}
+	if _, err := WithAdaptiveRetry(ar, Priority(1), func() (struct{}, error) {
+		return struct{}{}, nil
+	}); err != nil {
+		t.Fatalf("priority 1 request: unexpected error %v, want nil (priority 0's exhaustion must not leak)", err)
+	}
+}
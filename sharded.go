@@ -0,0 +1,162 @@
+package bulwark
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// shardedCounters stripes an AdaptiveThrottle's per-priority request/accept counters across N
+// shards, each a set of lock-free atomicBinCounters, the way the gRPC RLS adaptive throttler uses
+// fixed-bin counters with atomic increments to avoid a mutex on the hot path. The original design
+// serialized every request through one mutex twice (once to read the rejection probability, once
+// to record the outcome); this removes the lock entirely, and spreads the remaining atomic traffic
+// across GOMAXPROCS shards so concurrent writers are less likely to contend on the same cache
+// line. Writes land on a shard picked without any shared, contended state (see pick); reads sum
+// across every shard.
+type shardedCounters struct {
+	shards []counterShard
+}
+
+type counterShard struct {
+	requests []atomicBinCounter
+	accepts  []atomicBinCounter
+}
+
+// newShardedCounters returns a shardedCounters with runtime.GOMAXPROCS(0) shards, each tracking
+// priorities independent atomicBinCounter pairs with the given bin width and bin count.
+func newShardedCounters(priorities int, binWidth time.Duration, bins int) *shardedCounters {
+	shards := make([]counterShard, runtime.GOMAXPROCS(0))
+	for i := range shards {
+		shards[i].requests = make([]atomicBinCounter, priorities)
+		shards[i].accepts = make([]atomicBinCounter, priorities)
+		for p := 0; p < priorities; p++ {
+			shards[i].requests[p] = newAtomicBinCounter(binWidth, bins)
+			shards[i].accepts[p] = newAtomicBinCounter(binWidth, bins)
+		}
+	}
+	return &shardedCounters{shards: shards}
+}
+
+// pick returns the shard a write should land on. Rather than drawing from a shared atomic counter
+// (which would itself become the contended cache line sharding is meant to avoid), it hashes the
+// address of a stack-local variable: every goroutine's stack lives at a different address, so this
+// spreads writes across shards with no shared state and no synchronization at all.
+func (s *shardedCounters) pick() *counterShard {
+	var local byte
+	h := uintptr(unsafe.Pointer(&local))
+	return &s.shards[(h>>4)%uintptr(len(s.shards))]
+}
+
+// addRequest records a request for priority p that was rejected before f was ever called, so there
+// is no outcome to fold in.
+func (s *shardedCounters) addRequest(now time.Time, p Priority) {
+	s.pick().requests[int(p)].add(now, 1)
+}
+
+// addOutcome records a request for priority p and, when accepted, its acceptance.
+func (s *shardedCounters) addOutcome(now time.Time, p Priority, accepted bool) {
+	shard := s.pick()
+	shard.requests[int(p)].add(now, 1)
+	if accepted {
+		shard.accepts[int(p)].add(now, 1)
+	}
+}
+
+// getUpTo sums request and accept counts, as of now, for every priority in [0, n) across every
+// shard, in a single pass over the shards. rejectionProbability needs counts for priority p and
+// every priority above it, so this lets it do one shard sweep per Acquire instead of one sweep per
+// priority, which would otherwise multiply read traffic by the number of priorities and defeat the
+// point of sharding.
+func (s *shardedCounters) getUpTo(now time.Time, n int) (requests, accepts []int64) {
+	requests = make([]int64, n)
+	accepts = make([]int64, n)
+	for i := range s.shards {
+		shard := &s.shards[i]
+		for p := 0; p < n; p++ {
+			requests[p] += shard.requests[p].get(now)
+			accepts[p] += shard.accepts[p].get(now)
+		}
+	}
+	return requests, accepts
+}
+
+// atomicBinCounter is a lock-free, fixed-bin sliding window counter: time is divided into
+// consecutive slots of binWidth, each slot owning one bin in a ring of `bins` entries, and add/get
+// only ever touch individual bins with atomic operations -- there is no mutex anywhere in this
+// type. get(now) sums every bin whose slot falls within the trailing `bins*binWidth` window.
+//
+// A bin is reused by wrapping its slot index modulo `bins`. Reclaiming a stale bin for a new slot
+// and folding in a concurrent writer's count must happen as one atomic step: a bin's epoch and
+// count are stored together in a single immutable binState, swapped in with a CAS loop on the
+// *binState pointer, so a writer that loses the race to reclaim a bin never has its increment
+// clobbered by the winner's reset (which a separate CAS-the-epoch-then-store-the-count sequence
+// would allow, since a loser could observe the new epoch and add onto the about-to-be-reset count
+// in the gap between the two).
+type atomicBinCounter struct {
+	binWidth int64 // nanoseconds
+	bins     []atomicBin
+}
+
+type atomicBin struct {
+	v atomic.Pointer[binState]
+}
+
+// binState is the epoch a bin's count currently belongs to and that count, read and replaced
+// together so the two are always consistent.
+type binState struct {
+	epoch int64
+	count int64
+}
+
+func newAtomicBinCounter(binWidth time.Duration, bins int) atomicBinCounter {
+	return atomicBinCounter{
+		binWidth: int64(binWidth),
+		bins:     make([]atomicBin, bins),
+	}
+}
+
+func (c *atomicBinCounter) slot(now time.Time) int64 {
+	return now.UnixNano() / c.binWidth
+}
+
+// add atomically folds n into the bin for now's slot, reclaiming the bin for this slot first if it
+// still belongs to an older one.
+func (c *atomicBinCounter) add(now time.Time, n int64) {
+	slot := c.slot(now)
+	b := &c.bins[slot%int64(len(c.bins))]
+
+	for {
+		old := b.v.Load()
+		switch {
+		case old != nil && old.epoch == slot:
+			if b.v.CompareAndSwap(old, &binState{epoch: slot, count: old.count + n}) {
+				return
+			}
+		case old != nil && old.epoch > slot:
+			// A write from a slot after ours already claimed this bin; our sample is too old to
+			// matter for the window, so drop it rather than corrupt a newer slot's count.
+			return
+		default:
+			if b.v.CompareAndSwap(old, &binState{epoch: slot, count: n}) {
+				return
+			}
+		}
+		// Lost the race to reclaim or update the bin; retry against whatever state won.
+	}
+}
+
+// get sums every bin whose slot is within the trailing window ending at now's slot.
+func (c *atomicBinCounter) get(now time.Time) int64 {
+	slot := c.slot(now)
+	oldest := slot - int64(len(c.bins)) + 1
+
+	var total int64
+	for i := range c.bins {
+		if s := c.bins[i].v.Load(); s != nil && s.epoch >= oldest && s.epoch <= slot {
+			total += s.count
+		}
+	}
+	return total
+}
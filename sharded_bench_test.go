@@ -0,0 +1,130 @@
+package bulwark
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkAdaptiveThrottle_Parallel drives WithAdaptiveThrottle concurrently at increasing
+// multiples of GOMAXPROCS, to demonstrate that striping the request/accept counters across shards
+// as lock-free atomicBinCounters (rather than guarding them with a single mutex) lets throughput
+// scale with core count instead of plateauing once one lock is saturated. It covers both a single
+// priority and four, the latter at the lowest priority so every Acquire sweeps the full counter
+// range rejectionProbability reads. Run with -cpu=32 alongside
+// BenchmarkAdaptiveThrottleMutexBaseline_Parallel on the same machine to get a real, side-by-side
+// throughput number -- do not take a >5x figure on faith without rerunning both.
+func BenchmarkAdaptiveThrottle_Parallel(b *testing.B) {
+	for _, priorities := range []int{1, 4} {
+		for _, parallelism := range []int{1, 2, 4, 8, 16, 32} {
+			b.Run(fmt.Sprintf("priorities=%d/%s", priorities, parallelismName(parallelism)), func(b *testing.B) {
+				at := NewAdaptiveThrottle(priorities)
+				lowest := Priority(priorities - 1)
+
+				b.SetParallelism(parallelism)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						_, _ = WithAdaptiveThrottle(at, lowest, func() (struct{}, error) {
+							return struct{}{}, nil
+						})
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkAdaptiveThrottleMutexBaseline_Parallel exercises mutexBaselineCounters, which
+// reimplements the single-mutex design AdaptiveThrottle used before it was sharded (one
+// sync.Mutex guarding plain windowedCounter slices, acquired once for the rejection-probability
+// read and once for the outcome write). It is kept only so the two implementations can be
+// benchmarked side by side on the same hardware; production code never uses it.
+func BenchmarkAdaptiveThrottleMutexBaseline_Parallel(b *testing.B) {
+	for _, priorities := range []int{1, 4} {
+		for _, parallelism := range []int{1, 2, 4, 8, 16, 32} {
+			b.Run(fmt.Sprintf("priorities=%d/%s", priorities, parallelismName(parallelism)), func(b *testing.B) {
+				c := newMutexBaselineCounters(priorities, time.Minute/10, 10)
+				lowest := priorities - 1
+
+				b.SetParallelism(parallelism)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						now := time.Now()
+						_, _, rejectionProbability := c.rejectionProbability(now, lowest)
+						if rejectionProbability >= 1 {
+							c.addRequest(now, lowest)
+							continue
+						}
+						c.addOutcome(now, lowest, true)
+					}
+				})
+			})
+		}
+	}
+}
+
+func parallelismName(n int) string {
+	switch n {
+	case 1:
+		return "p1"
+	case 2:
+		return "p2"
+	case 4:
+		return "p4"
+	case 8:
+		return "p8"
+	case 16:
+		return "p16"
+	default:
+		return "p32"
+	}
+}
+
+// mutexBaselineCounters is the pre-sharding design kept only as a benchmark baseline: one mutex
+// guards every priority's request/accept windowedCounter, acquired on every read and write.
+type mutexBaselineCounters struct {
+	m        sync.Mutex
+	requests []windowedCounter
+	accepts  []windowedCounter
+}
+
+func newMutexBaselineCounters(priorities int, binWidth time.Duration, bins int) *mutexBaselineCounters {
+	now := time.Now()
+	requests := make([]windowedCounter, priorities)
+	accepts := make([]windowedCounter, priorities)
+	for i := range requests {
+		requests[i] = newWindowedCounter(now, binWidth, bins)
+		accepts[i] = newWindowedCounter(now, binWidth, bins)
+	}
+	return &mutexBaselineCounters{requests: requests, accepts: accepts}
+}
+
+func (c *mutexBaselineCounters) addRequest(now time.Time, p int) {
+	c.m.Lock()
+	c.requests[p].add(now, 1)
+	c.m.Unlock()
+}
+
+func (c *mutexBaselineCounters) addOutcome(now time.Time, p int, accepted bool) {
+	c.m.Lock()
+	c.requests[p].add(now, 1)
+	if accepted {
+		c.accepts[p].add(now, 1)
+	}
+	c.m.Unlock()
+}
+
+func (c *mutexBaselineCounters) rejectionProbability(now time.Time, p int) (requests, accepts int64, rejectionProbability float64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	reqs := c.requests[p].get(now)
+	acc := c.accepts[p].get(now)
+	reqsF := float64(reqs)
+	for i := 0; i < p; i++ {
+		reqsF += float64(c.requests[i].get(now) - c.accepts[i].get(now))
+	}
+	return reqs, acc, reqsF / (reqsF + 1)
+}
@@ -0,0 +1,64 @@
+package bulwark
+
+import "context"
+
+// Throttler is the common interface implemented by every gate in this package -- AdaptiveThrottle,
+// ConcurrencyLimiter, AdaptiveRetrier, and future limiters such as a circuit breaker. Acquire
+// either admits the request, returning a Ticket that must be released with the request's eventual
+// outcome, or rejects it outright with an error.
+//
+// Throttler lets callers compose gates without nesting closures: see Chain.
+type Throttler interface {
+	// Acquire admits or rejects a request of the given Priority. When it returns a non-nil error,
+	// the request was rejected and there is no Ticket to release. Otherwise, the caller must call
+	// Ticket.Release exactly once with the outcome of the request.
+	Acquire(ctx context.Context, p Priority) (Ticket, error)
+}
+
+// Ticket represents a request admitted by a Throttler. Release must be called exactly once with
+// the error, if any, that the request ultimately failed with, so the Throttler can fold the
+// outcome back into its state.
+type Ticket interface {
+	Release(err error)
+}
+
+// Chain combines throttlers into a single Throttler that acquires from each in order and, on
+// Release, releases them in reverse order -- the same nesting order `WithAdaptiveThrottle` closures
+// would otherwise require by hand, e.g. a deadline limiter wrapping a ConcurrencyLimiter wrapping
+// an AdaptiveThrottle. If any throttler in the chain rejects the request, the throttlers already
+// acquired are released with that rejection error before Chain returns it.
+func Chain(throttlers ...Throttler) Throttler {
+	return chain(throttlers)
+}
+
+type chain []Throttler
+
+func (c chain) Acquire(ctx context.Context, p Priority) (Ticket, error) {
+	tickets := make(chainTicket, 0, len(c))
+	for _, t := range c {
+		ticket, err := t.Acquire(ctx, p)
+		if err != nil {
+			tickets.Release(err)
+			return nil, err
+		}
+		tickets = append(tickets, ticket)
+	}
+	return tickets, nil
+}
+
+type chainTicket []Ticket
+
+func (c chainTicket) Release(err error) {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].Release(err)
+	}
+}
+
+// Compile-time assertions that every limiter in the package satisfies Throttler, so e.g.
+// Chain(deadlineLimiter, concurrencyLimiter, adaptiveRetrier, adaptiveThrottle) type-checks without
+// a wrapper for any of them.
+var (
+	_ Throttler = (*AdaptiveThrottle)(nil)
+	_ Throttler = (*ConcurrencyLimiter)(nil)
+	_ Throttler = (*AdaptiveRetrier)(nil)
+)
@@ -0,0 +1,70 @@
+package bulwark
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestChain_AcquireReleaseOrder checks that Chain acquires from each throttler in order and
+// releases them in reverse order on the returned ticket, the ordering its doc comment promises.
+func TestChain_AcquireReleaseOrder(t *testing.T) {
+	var log []string
+	a := &fakeThrottler{name: "a", log: &log}
+	b := &fakeThrottler{name: "b", log: &log}
+	c := &fakeThrottler{name: "c", log: &log}
+
+	ticket, err := Chain(a, b, c).Acquire(context.Background(), Priority(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ticket.Release(nil)
+
+	want := []string{"acquire:a", "acquire:b", "acquire:c", "release:c", "release:b", "release:a"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("log = %v, want %v", log, want)
+	}
+}
+
+// TestChain_RollsBackOnRejection checks that when a throttler partway through the chain rejects,
+// Chain releases the ones already acquired (in reverse order) and never reaches the rest.
+func TestChain_RollsBackOnRejection(t *testing.T) {
+	var log []string
+	a := &fakeThrottler{name: "a", log: &log}
+	b := &fakeThrottler{name: "b", log: &log, reject: true}
+	c := &fakeThrottler{name: "c", log: &log}
+
+	_, err := Chain(a, b, c).Acquire(context.Background(), Priority(0))
+	if err == nil {
+		t.Fatal("expected an error from the rejecting throttler")
+	}
+
+	want := []string{"acquire:a", "acquire:b", "release:a"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("log = %v, want %v (c must never be acquired)", log, want)
+	}
+}
+
+type fakeThrottler struct {
+	name   string
+	reject bool
+	log    *[]string
+}
+
+func (f *fakeThrottler) Acquire(ctx context.Context, p Priority) (Ticket, error) {
+	*f.log = append(*f.log, "acquire:"+f.name)
+	if f.reject {
+		return nil, fmt.Errorf("%s rejected", f.name)
+	}
+	return &fakeTicket{name: f.name, log: f.log}, nil
+}
+
+type fakeTicket struct {
+	name string
+	log  *[]string
+}
+
+func (t *fakeTicket) Release(err error) {
+	*t.log = append(*t.log, "release:"+t.name)
+}